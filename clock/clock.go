@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package clock provides pluggable time sources so latency measurements
+// taken from data channel timestamps mean something across hosts whose
+// wall clocks aren't already tightly disciplined against each other.
+package clock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	fastclock "github.com/likhith/fastclock"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Source is anything that can report the current time. Swapping the
+// implementation lets a handler call Source.Now() once instead of choosing
+// between time.Now() and a disciplined clock at every call site.
+type Source interface {
+	Now() time.Time
+}
+
+// Kind selects a Source implementation, typically from a command-line flag.
+type Kind string
+
+const (
+	KindWall   Kind = "wall"
+	KindHybrid Kind = "hybrid"
+	KindNTP    Kind = "ntp"
+)
+
+// NewSource builds the Source selected by kind. KindNTP returns an *Offset
+// with a zero offset; callers should drive it with a handshake (see
+// RunHandshake) to actually correct for skew.
+func NewSource(kind Kind) (Source, error) {
+	switch kind {
+	case KindWall, "":
+		return Wall{}, nil
+	case KindHybrid:
+		return NewHybrid(), nil
+	case KindNTP:
+		return NewOffset(), nil
+	default:
+		return nil, fmt.Errorf("clock: unknown kind %q", kind)
+	}
+}
+
+// Wall is the default Source: plain time.Now().
+type Wall struct{}
+
+// Now implements Source.
+func (Wall) Now() time.Time { return time.Now() }
+
+// Hybrid wraps fastclock.HybridClock, which tracks the monotonic clock
+// between infrequent wall-clock resyncs to avoid NTP step discontinuities
+// mid-measurement.
+type Hybrid struct {
+	hc *fastclock.HybridClock
+}
+
+// NewHybrid constructs a Hybrid Source.
+func NewHybrid() *Hybrid {
+	return &Hybrid{hc: fastclock.NewHybridClock()}
+}
+
+// Now implements Source.
+func (h *Hybrid) Now() time.Time { return h.hc.Now() }
+
+// Offset is a wall clock corrected by an externally-supplied offset, the
+// same model chrony/ntpd use: the local oscillator is trusted for short
+// intervals, and a periodically-recomputed offset keeps it aligned with a
+// remote peer's clock. SetOffset is meant to be called from a handshake
+// like the one in RunHandshake.
+type Offset struct {
+	mu     sync.RWMutex
+	offset time.Duration
+}
+
+// NewOffset constructs an Offset Source with a zero offset.
+func NewOffset() *Offset {
+	return &Offset{}
+}
+
+// Now implements Source.
+func (o *Offset) Now() time.Time {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return time.Now().Add(o.offset)
+}
+
+// SetOffset updates the correction applied by Now and publishes it as the
+// webrtc_clock_offset_ms gauge so operators can see skew.
+func (o *Offset) SetOffset(d time.Duration) {
+	o.mu.Lock()
+	o.offset = d
+	o.mu.Unlock()
+
+	clockOffsetMs.Set(float64(d.Microseconds()) / 1000)
+}
+
+var clockOffsetMs = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "webrtc_clock_offset_ms",
+	Help: "Offset currently applied by clock.Offset, as measured by the last completed handshake",
+})
+
+func init() {
+	prometheus.MustRegister(clockOffsetMs)
+}
@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package clock
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const handshakeMessageType = "clock_sync"
+
+// handshakeMessage carries the classic NTP t1/t2/t3 triple (t4 is the
+// receive timestamp, taken locally rather than serialized). A zero T2
+// marks a request; T2/T3 being set marks the response to it.
+type handshakeMessage struct {
+	Type string `json:"type"`
+	T1   int64  `json:"t1,omitempty"`
+	T2   int64  `json:"t2,omitempty"`
+	T3   int64  `json:"t3,omitempty"`
+}
+
+// IsHandshakeMessage reports whether data is a message produced by this
+// package, so callers can route it away from their own unmarshal before
+// treating it as application data.
+func IsHandshakeMessage(data []byte) bool {
+	var probe struct {
+		Type string `json:"type"`
+	}
+
+	return json.Unmarshal(data, &probe) == nil && probe.Type == handshakeMessageType
+}
+
+// RunHandshake sends the t1 leg of a one-way-delay probe on dc. The peer is
+// expected to answer with HandleHandshakeMessage running on its own
+// OnMessage handler; our half of the response is completed by
+// HandleHandshakeMessage running here once that answer arrives.
+func RunHandshake(dc *webrtc.DataChannel, local Source) error {
+	msg := handshakeMessage{
+		Type: handshakeMessageType,
+		T1:   local.Now().UnixMicro(),
+	}
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("clock: marshal handshake request: %w", err)
+	}
+
+	return dc.Send(b)
+}
+
+// HandleHandshakeMessage processes a message for which IsHandshakeMessage
+// returned true: it answers a request in place, or, for a response to a
+// request we sent earlier via RunHandshake, computes the offset between
+// local and the peer's clock and applies it to target via target.SetOffset.
+func HandleHandshakeMessage(dc *webrtc.DataChannel, local Source, target *Offset, data []byte) error {
+	var msg handshakeMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return fmt.Errorf("clock: unmarshal handshake message: %w", err)
+	}
+
+	if msg.T2 == 0 {
+		now := local.Now().UnixMicro()
+		msg.T2 = now
+		msg.T3 = now
+
+		b, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("clock: marshal handshake response: %w", err)
+		}
+
+		return dc.Send(b)
+	}
+
+	t4 := local.Now().UnixMicro()
+	offsetMicros := ((msg.T2 - msg.T1) + (msg.T3 - t4)) / 2
+	target.SetOffset(time.Duration(offsetMicros) * time.Microsecond)
+
+	return nil
+}
@@ -10,145 +10,61 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/base64"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
-	fastclock "github.com/likhith/fastclock"
 	"github.com/pion/webrtc/v4"
-	"github.com/pion/webrtc/v4/pkg/media"
 	"github.com/pion/webrtc/v4/pkg/media/ivfreader"
-	"github.com/pion/webrtc/v4/pkg/media/oggreader"
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/LikhithST/webrtc_client/clock"
+	"github.com/LikhithST/webrtc_client/mediasource"
+	"github.com/LikhithST/webrtc_client/metrics"
+	"github.com/LikhithST/webrtc_client/signaling/whep"
+	"github.com/LikhithST/webrtc_client/signaling/whip"
 )
 
 const (
-	audioFileName   = "output.ogg"
-	videoFileName   = "output.ivf"
-	oggPageDuration = time.Millisecond * 20
-	httpEndpoint    = "https://webrtc.hopto.org:8080/offer" // Replace with your HTTP endpoint URL
+	audioFileName = "output.ogg"
+	videoFileName = "output.ivf"
+	whipEndpoint  = "https://webrtc.hopto.org:8080/whip/endpoint" // Replace with your WHIP ingest URL
+
+	// RTP clock rates for the codecs this client sends, used to convert
+	// RTCP Receiver Report jitter (in RTP timestamp units) to milliseconds.
+	videoClockRateHz = 90000
+	audioClockRateHz = 48000
 )
 
-type DataChannelMessage struct {
-	FrameID                int64  `json:"frameID"`
-	MessageSentTimeClient2 int64  `json:"messageSentTime_client2,omitempty"`
-	MessageSentTimeSfu2    int64  `json:"messageSentTime_sfu2,omitempty"`
-	MessageSentTimeSfu1    int64  `json:"messageSentTime_sfu1,omitempty"`
-	MessageSentTimeClient1 int64  `json:"messageSentTime_client1,omitempty"`
-	JitterSFU2             int64  `json:"jitter_sfu2,omitempty"`
-	JitterSFU1             int64  `json:"jitter_sfu1,omitempty"`
-	LatencyEndToEnd        int64  `json:"latency_end_to_end,omitempty"`
-	MessageSendRate        int64  `json:"message_send_rate,omitempty"`
-	Payload                []byte `json:"payload"`
-}
-
-var stats = struct {
-	FrameID                *prometheus.GaugeVec
-	MessageSentTimeClient2 *prometheus.GaugeVec
-	MessageSentTimeSfu2    *prometheus.GaugeVec
-	MessageSentTimeSfu1    *prometheus.GaugeVec
-	MessageSentTimeClient1 *prometheus.GaugeVec
-	LatencyEndToEnd        *prometheus.GaugeVec
-	LatencyClient2ToSfu2   *prometheus.GaugeVec
-	LatencySfu2ToSfu1      *prometheus.GaugeVec
-	LatencySfu1ToClient1   *prometheus.GaugeVec
-}{
-	FrameID: prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "webrtc_frame_id",
-			Help: "The ID of the frame being processed",
-		},
-		[]string{"frame_id"},
-	),
-	MessageSentTimeClient2: prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "webrtc_message_sent_time_client2",
-			Help: "The time when the message was sent from client 2",
-		},
-		[]string{"message_sent_time_client2"},
-	),
-	MessageSentTimeSfu2: prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "webrtc_message_sent_time_sfu2",
-			Help: "The time when the message was sent from SFU 2",
-		},
-		[]string{"message_sent_time_sfu2"},
-	),
-	MessageSentTimeSfu1: prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "webrtc_message_sent_time_sfu1",
-			Help: "The time when the message was sent from SFU 1",
-		},
-		[]string{"message_sent_time_sfu1"},
-	),
-	MessageSentTimeClient1: prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "webrtc_message_sent_time_client1",
-			Help: "The time when the message was sent from client 1",
-		},
-		[]string{"message_sent_time_client1"},
-	),
-	LatencyEndToEnd: prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "webrtc_latency_end_to_end",
-			Help: "End-to-end latency of the message",
-		},
-		[]string{"latency_end_to_end"},
-	),
-	LatencyClient2ToSfu2: prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "webrtc_latency_client2_to_sfu2",
-			Help: "Latency from client 2 to SFU 2",
-		},
-		[]string{"latency_client2_to_sfu2"},
-	),
-	LatencySfu2ToSfu1: prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "webrtc_latency_sfu2_to_sfu1",
-			Help: "Latency from SFU 2 to SFU 1",
-		},
-		[]string{"latency_sfu2_to_sfu1"},
-	),
-	LatencySfu1ToClient1: prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "webrtc_latency_sfu1_to_client1",
-			Help: "Latency from SFU 1 to client 1",
-		},
-		[]string{"latency_sfu1_to_client1"},
-	),
-}
-
-func init() {
-	// we need to register the counter so prometheus can collect this metric
-	log.Println("init() function called")
-	prometheus.MustRegister(
-		stats.FrameID,
-		stats.MessageSentTimeClient2,
-		stats.MessageSentTimeSfu2,
-		stats.MessageSentTimeSfu1,
-		stats.MessageSentTimeClient1,
-		stats.LatencyClient2ToSfu2,
-		stats.LatencySfu2ToSfu1,
-		stats.LatencySfu1ToClient1,
-		stats.LatencyEndToEnd,
-	)
+// iceServers is shared between the outbound WHIP peer connection and every
+// inbound WHEP peer connection the server side creates.
+var iceServers = []webrtc.ICEServer{
+	{URLs: []string{"stun:stun.l.google.com:19302"}},
 }
 
 func main() {
+	clockKind := flag.String("clock", string(clock.KindWall),
+		"time source for latency timestamps: wall, hybrid, or ntp")
+	flag.Parse()
+
+	clockSource, err := clock.NewSource(clock.Kind(*clockKind))
+	if err != nil {
+		panic(err)
+	}
+	// Only the ntp kind needs driving by the handshake below; the others
+	// are self-contained.
+	offsetClock, _ := clockSource.(*clock.Offset)
 
-	httpPromServer()
 	// Assert that we have an audio or video file
-	_, err := os.Stat(videoFileName)
+	_, err = os.Stat(videoFileName)
 	haveVideoFile := !os.IsNotExist(err)
 
 	_, err = os.Stat(audioFileName)
@@ -159,13 +75,7 @@ func main() {
 	}
 
 	// Create a new RTCPeerConnection
-	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: []string{"stun:stun.l.google.com:19302"},
-			},
-		},
-	})
+	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{ICEServers: iceServers})
 	if err != nil {
 		panic(err)
 	}
@@ -175,23 +85,40 @@ func main() {
 		}
 	}()
 
+	whipClient := whip.NewClient(whipEndpoint, os.Getenv("WHIP_BEARER_TOKEN"), nil)
+	defer func() {
+		if cErr := whipClient.Close(context.Background()); cErr != nil {
+			fmt.Printf("cannot close WHIP session: %v\n", cErr)
+		}
+	}()
+
 	dataChannel, err := peerConnection.CreateDataChannel("data", nil)
 	if err != nil {
 		panic(err)
 	}
 
-	var NewHybridClock *fastclock.HybridClock
-	_ = NewHybridClock
 	// Register channel opening handling
 	dataChannel.OnOpen(func() {
-		NewHybridClock = fastclock.NewHybridClock()
 		fmt.Printf(
 			"Data channel '%s'-'%d' open. Random messages will now be sent to any connected DataChannels every 5 seconds\n",
 			dataChannel.Label(), dataChannel.ID(),
 		)
 
+		go func() {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for range ticker.C {
+				metrics.SetDataChannelBufferedAmount(float64(dataChannel.BufferedAmount()))
+			}
+		}()
+
+		if offsetClock != nil {
+			if hsErr := clock.RunHandshake(dataChannel, clockSource); hsErr != nil {
+				fmt.Printf("clock handshake failed: %v\n", hsErr)
+			}
+		}
 	})
-	logChan := make(chan DataChannelMessage, 100)
+	logChan := make(chan metrics.DataChannelMessage, 100)
 	go func() {
 		file, err := os.Create("datachannel_messages.csv")
 		if err != nil {
@@ -229,37 +156,41 @@ func main() {
 
 	// Register text message handling
 	dataChannel.OnMessage(func(msg webrtc.DataChannelMessage) {
-		var frameData DataChannelMessage
-		err := json.Unmarshal(msg.Data, &frameData)
-		if err != nil {
+		if clock.IsHandshakeMessage(msg.Data) {
+			if offsetClock == nil {
+				return
+			}
+			if hsErr := clock.HandleHandshakeMessage(dataChannel, clockSource, offsetClock, msg.Data); hsErr != nil {
+				fmt.Printf("clock handshake message error: %v\n", hsErr)
+			}
+			return
+		}
+
+		var frameData metrics.DataChannelMessage
+		if err := json.Unmarshal(msg.Data, &frameData); err != nil {
 			fmt.Println("Error unmarshalling:", err)
+			metrics.ObserveUnmarshalError()
 			return
 		}
-		// frameData.MessageSentTimeClient1 = NewHybridClock.Now().UnixMilli()
-		frameData.MessageSentTimeClient1 = time.Now().UnixMilli()
-		stats.FrameID.WithLabelValues("FrameID").Set(float64(frameData.FrameID))
-		stats.MessageSentTimeClient2.WithLabelValues("MessageSentTimeClient2").Set(float64(frameData.MessageSentTimeClient2))
-		stats.MessageSentTimeSfu2.WithLabelValues("MessageSentTimeSfu2").Set(float64(frameData.MessageSentTimeSfu2))
-		stats.MessageSentTimeSfu1.WithLabelValues("MessageSentTimeSfu1").Set(float64(frameData.MessageSentTimeSfu1))
-		stats.MessageSentTimeClient1.WithLabelValues("MessageSentTimeClient1").Set(float64(frameData.MessageSentTimeClient1))
-		stats.LatencyEndToEnd.WithLabelValues("LatencyEndToEnd").Set(
-			float64(frameData.MessageSentTimeClient1 - frameData.MessageSentTimeClient2))
-		stats.LatencyClient2ToSfu2.WithLabelValues("LatencyClient2ToSfu2").Set(
-			float64(frameData.MessageSentTimeSfu2 - frameData.MessageSentTimeClient2),
-		)
-		stats.LatencySfu2ToSfu1.WithLabelValues("LatencySfu2ToSfu1").Set(
-			float64(frameData.MessageSentTimeSfu1 - frameData.MessageSentTimeSfu2),
-		)
-		stats.LatencySfu1ToClient1.WithLabelValues("LatencySfu1ToClient1").Set(
-			float64(frameData.MessageSentTimeClient1 - frameData.MessageSentTimeSfu1),
-		)
-		frameData.LatencyEndToEnd = frameData.MessageSentTimeClient1 - frameData.MessageSentTimeClient2
-		// fmt.Printf("Message from DataChannel '%s': \n frameID: '%d', client2: '%d', sfu2: '%d', sfu1: '%d', client1: '%d'\n", dataChannel.Label(), frameData.FrameID, frameData.MessageSentTimeClient2, frameData.MessageSentTimeSfu2, frameData.MessageSentTimeSfu1, frameData.MessageSentTimeClient1)
+		frameData.MessageSentTimeClient1 = clockSource.Now().UnixMilli()
+		frameData = metrics.Observe(frameData)
 		logChan <- frameData
 	})
 
 	iceConnectedCtx, iceConnectedCtxCancel := context.WithCancel(context.Background())
 
+	// videoSourceFormat/audioSourceFormat record what output.ivf/output.ogg
+	// are encoded as, so the WHEP server registered below can negotiate
+	// each viewer's own track via mediasource.NewTrack instead of assuming
+	// they support whatever codec the source file happens to use.
+	var videoSourceFormat, audioSourceFormat mediasource.SourceFormat
+
+	// videoHub/audioHub fan the decoded file out to every subscribed
+	// track; besides the outbound videoTrack/audioTrack below, the WHEP
+	// server registered after both blocks subscribes a fresh track per
+	// incoming viewer to the same hub.
+	var videoHub, audioHub *mediasource.Hub
+
 	if haveVideoFile {
 		file, openErr := os.Open(videoFileName)
 		if openErr != nil {
@@ -267,6 +198,7 @@ func main() {
 		}
 
 		_, header, openErr := ivfreader.NewWith(file)
+		file.Close()
 		if openErr != nil {
 			panic(openErr)
 		}
@@ -276,10 +208,13 @@ func main() {
 		switch header.FourCC {
 		case "AV01":
 			trackCodec = webrtc.MimeTypeAV1
+			videoSourceFormat = mediasource.SourceAV1
 		case "VP90":
 			trackCodec = webrtc.MimeTypeVP9
+			videoSourceFormat = mediasource.SourceVP9
 		case "VP80":
 			trackCodec = webrtc.MimeTypeVP8
+			videoSourceFormat = mediasource.SourceVP8
 		default:
 			panic(fmt.Sprintf("Unable to handle FourCC %s", header.FourCC))
 		}
@@ -297,62 +232,33 @@ func main() {
 			panic(videoTrackErr)
 		}
 
-		// Read incoming RTCP packets
-		go func() {
-			rtcpBuf := make([]byte, 1500)
-			for {
-				if _, _, rtcpErr := rtpSender.Read(rtcpBuf); rtcpErr != nil {
-					return
-				}
-			}
-		}()
+		// Feed incoming Receiver Reports into the RTP QoS gauges and send
+		// our own Sender Reports so the SFU can compute RTT.
+		go metrics.MonitorRTPStream(context.Background(), "video", videoClockRateHz, peerConnection, rtpSender)
+
+		// One decode loop feeds every subscribed track; today that's just
+		// this peer connection's videoTrack, but additional WHEP sessions
+		// can subscribe to the same hub without re-decoding the file.
+		videoHub = mediasource.NewHub("video")
+		unsubscribeVideo := videoHub.Subscribe(videoTrack)
+		defer unsubscribeVideo()
 
 		go func() {
-			// Open a IVF file and start reading using our IVFReader
-			file, ivfErr := os.Open(videoFileName)
-			if ivfErr != nil {
-				panic(ivfErr)
-			}
+			// Wait for connection established
+			<-iceConnectedCtx.Done()
 
 			for {
-
-				if _, err := file.Seek(0, io.SeekStart); err != nil {
-					panic(fmt.Errorf("failed to rewind file: %w", err))
+				fmt.Println("Streaming video, will restart on EOF...")
+				if srcErr := mediasource.RunIVFSource(context.Background(), videoFileName, videoHub); srcErr != nil {
+					panic(srcErr)
 				}
-
-				ivf, header, ivfErr := ivfreader.NewWith(file)
-				if ivfErr != nil {
-					panic(ivfErr)
-				}
-
-				// Wait for connection established
-				<-iceConnectedCtx.Done()
-				// Send our video file frame at a time
-				ticker := time.NewTicker(
-					time.Millisecond * time.Duration((float32(header.TimebaseNumerator)/float32(header.TimebaseDenominator))*1000),
-				)
-				defer ticker.Stop()
-				for ; true; <-ticker.C {
-					frame, _, ivfErr := ivf.ParseNextFrame()
-					if errors.Is(ivfErr, io.EOF) {
-						fmt.Println("Reached end of video, restarting...")
-						break
-					}
-
-					if ivfErr != nil {
-						panic(ivfErr)
-					}
-
-					if ivfErr = videoTrack.WriteSample(media.Sample{Data: frame, Duration: time.Second}); ivfErr != nil {
-						panic(ivfErr)
-					}
-				}
-
 			}
 		}()
 	}
 
 	if haveAudioFile {
+		audioSourceFormat = mediasource.SourceOpus
+
 		// Create an audio track
 		audioTrack, audioTrackErr := webrtc.NewTrackLocalStaticSample(
 			webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "pion",
@@ -366,60 +272,28 @@ func main() {
 			panic(audioTrackErr)
 		}
 
-		// Read incoming RTCP packets
-		go func() {
-			rtcpBuf := make([]byte, 1500)
-			for {
-				if _, _, rtcpErr := rtpSender.Read(rtcpBuf); rtcpErr != nil {
-					return
-				}
-			}
-		}()
-
-		go func() {
-			// Open an OGG file and start reading using our OGGReader
-			file, oggErr := os.Open(audioFileName)
-			if oggErr != nil {
-				panic(oggErr)
-			}
+		// Feed incoming Receiver Reports into the RTP QoS gauges and send
+		// our own Sender Reports so the SFU can compute RTT.
+		go metrics.MonitorRTPStream(context.Background(), "audio", audioClockRateHz, peerConnection, rtpSender)
 
-			ogg, _, oggErr := oggreader.NewWith(file)
-			if oggErr != nil {
-				panic(oggErr)
-			}
+		audioHub = mediasource.NewHub("audio")
+		unsubscribeAudio := audioHub.Subscribe(audioTrack)
+		defer unsubscribeAudio()
 
+		go func() {
 			// Wait for connection established
 			<-iceConnectedCtx.Done()
 
-			// Keep track of last granule
-			var lastGranule uint64
-
-			// Send audio in a timely manner
-			ticker := time.NewTicker(oggPageDuration)
-			defer ticker.Stop()
-			for ; true; <-ticker.C {
-				pageData, pageHeader, oggErr := ogg.ParseNextPage()
-				if errors.Is(oggErr, io.EOF) {
-					fmt.Printf("All audio pages parsed and sent")
-					os.Exit(0)
-				}
-
-				if oggErr != nil {
-					panic(oggErr)
-				}
-
-				// The amount of samples is the difference between the last and current timestamp
-				sampleCount := float64(pageHeader.GranulePosition - lastGranule)
-				lastGranule = pageHeader.GranulePosition
-				sampleDuration := time.Duration((sampleCount/48000)*1000) * time.Millisecond
-
-				if oggErr = audioTrack.WriteSample(media.Sample{Data: pageData, Duration: sampleDuration}); oggErr != nil {
-					panic(oggErr)
-				}
+			// RunOGGSource restarts the file on EOF, so it only returns
+			// with an error.
+			if srcErr := mediasource.RunOGGSource(context.Background(), audioFileName, audioHub); srcErr != nil {
+				panic(srcErr)
 			}
 		}()
 	}
 
+	httpServer(videoHub, audioHub, videoSourceFormat, audioSourceFormat)
+
 	// Set the handler for ICE connection state change
 	peerConnection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
 		fmt.Printf("Connection State has changed %s \n", connectionState.String())
@@ -443,72 +317,48 @@ func main() {
 		}
 	})
 
-	// Create offer
-	offer, err := peerConnection.CreateOffer(nil)
-	if err != nil {
-		panic(err)
-	}
-
-	if err := peerConnection.SetLocalDescription(offer); err != nil {
-		panic(err)
-	}
+	// Stream trickle-ICE candidates to the WHIP resource as they're
+	// gathered instead of blocking on GatheringCompletePromise.
+	peerConnection.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
 
-	// Send offer to HTTP endpoint
-	offerBase64 := encode(&offer)
-	resp, err := http.Post(httpEndpoint, "application/json", strings.NewReader(offerBase64))
-	if err != nil {
-		panic(err)
-	}
-	defer resp.Body.Close()
+		if err := whipClient.TrickleCandidate(context.Background(), candidate.SDPMid, candidate); err != nil {
+			fmt.Printf("failed to trickle ICE candidate: %v\n", err)
+		}
+	})
 
-	// Read the response containing the answer
-	var answerBase64 string
-	println("Waiting for answer...", resp.Status, resp.Body)
-	respBody, err := io.ReadAll(resp.Body)
+	// Publish performs the WHIP offer/answer exchange and returns the
+	// per-session resource URL; whipClient keeps it for the trickling above
+	// and for the deferred Close that tears the session down.
+	resourceURL, err := whipClient.Publish(context.Background(), peerConnection)
 	if err != nil {
 		panic(err)
 	}
-	answerBase64 = strings.TrimSpace(string(respBody))
-	// Decode the answer and set it as the remote description
-	var answer webrtc.SessionDescription
-	decode(answerBase64, &answer)
-
-	fmt.Println("Answer received, setting remote description...", answerBase64, answer)
-	if err := peerConnection.SetRemoteDescription(answer); err != nil {
-		panic(err)
-	}
-
-	// Create answer
-	// answer, err = peerConnection.CreateAnswer(nil)
-	// if err != nil {
-	// 	panic(err)
-	// }
-
-	// Create channel that is blocked until ICE Gathering is complete
-	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
-
-	// Sets the LocalDescription, and starts our UDP listeners
-	// if err := peerConnection.SetLocalDescription(answer); err != nil {
-	// 	panic(err)
-	// }
-
-	// Block until ICE Gathering is complete, disabling trickle ICE
-	<-gatherComplete
-
-	// Output the answer in base64 so we can paste it in the browser
-	fmt.Println(encode(peerConnection.LocalDescription()))
+	fmt.Println("WHIP session published, resource URL:", resourceURL)
 
 	// Block forever
 	select {}
 }
 
-func httpPromServer() {
-	mux_s1 := http.NewServeMux()
-	mux_s1.Handle("/metrics", promhttp.Handler())
+// httpServer starts the metrics endpoint and, for whichever of videoHub/
+// audioHub are non-nil, a WHEP endpoint that subscribes a fresh viewer
+// track to that hub per incoming offer.
+func httpServer(videoHub, audioHub *mediasource.Hub, videoSourceFormat, audioSourceFormat mediasource.SourceFormat) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	whepServer := whep.NewServer(
+		whep.Track{Hub: videoHub, Kind: "video", SourceFormat: videoSourceFormat},
+		whep.Track{Hub: audioHub, Kind: "audio", SourceFormat: audioSourceFormat},
+		iceServers,
+	)
+	mux.Handle("/whep/", whepServer)
 
 	go func() {
 		// nolint: gosec
-		panic(http.ListenAndServe(":"+strconv.Itoa(8080), mux_s1))
+		panic(http.ListenAndServe(":"+strconv.Itoa(8080), mux))
 	}()
 }
 
@@ -532,25 +382,3 @@ func readUntilNewline() (in string) {
 
 	return
 }
-
-// JSON encode + base64 a SessionDescription.
-func encode(obj *webrtc.SessionDescription) string {
-	b, err := json.Marshal(obj)
-	if err != nil {
-		panic(err)
-	}
-
-	return base64.StdEncoding.EncodeToString(b)
-}
-
-// Decode a base64 and unmarshal JSON into a SessionDescription.
-func decode(in string, obj *webrtc.SessionDescription) {
-	b, err := base64.StdEncoding.DecodeString(in)
-	if err != nil {
-		panic(err)
-	}
-
-	if err = json.Unmarshal(b, obj); err != nil {
-		panic(err)
-	}
-}
@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package mediasource decodes a single upstream file (IVF or OGG) once and
+// fans the resulting media.Sample values out to any number of subscribing
+// tracks, so that N peer connections can be served from one decode loop
+// instead of each one re-reading the source file.
+package mediasource
+
+import (
+	"sync"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Sink receives samples produced by a Hub. webrtc.TrackLocalStaticSample
+// satisfies this interface, so tracks can be subscribed directly.
+type Sink interface {
+	WriteSample(sample media.Sample) error
+}
+
+// subscriberQueueDepth bounds how many samples a slow subscriber may lag
+// behind before new samples are dropped for it. Video/audio samples are
+// latency-sensitive, so we prefer dropping frames over buffering them.
+const subscriberQueueDepth = 8
+
+type subscriber struct {
+	sink    Sink
+	samples chan media.Sample
+	done    chan struct{}
+}
+
+// Hub fans out the samples produced by a single reader loop (see
+// RunIVFSource/RunOGGSource) to every currently-subscribed Sink.
+type Hub struct {
+	kind string // "video" or "audio", used as a metric label
+
+	mu   sync.Mutex
+	subs []*subscriber
+}
+
+// NewHub creates a Hub for the given media kind ("video" or "audio"). kind
+// is used only to label the package's Prometheus metrics.
+func NewHub(kind string) *Hub {
+	return &Hub{kind: kind}
+}
+
+// Subscribe registers sink to receive every future sample broadcast on the
+// hub and starts the goroutine that drains its queue into sink.WriteSample.
+// The returned unsubscribe func removes sink and stops that goroutine; it
+// is safe to call more than once.
+func (h *Hub) Subscribe(sink Sink) (unsubscribe func()) {
+	sub := &subscriber{
+		sink:    sink,
+		samples: make(chan media.Sample, subscriberQueueDepth),
+		done:    make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	h.subs = append(h.subs, sub)
+	h.mu.Unlock()
+
+	subscriberCount.WithLabelValues(h.kind).Inc()
+	go h.pump(sub)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			h.remove(sub)
+		})
+	}
+}
+
+func (h *Hub) remove(sub *subscriber) {
+	h.mu.Lock()
+	for i, s := range h.subs {
+		if s == sub {
+			// Slice splice: drop index i without preserving order.
+			h.subs[i] = h.subs[len(h.subs)-1]
+			h.subs = h.subs[:len(h.subs)-1]
+
+			break
+		}
+	}
+	h.mu.Unlock()
+
+	close(sub.done)
+	subscriberCount.WithLabelValues(h.kind).Dec()
+}
+
+func (h *Hub) pump(sub *subscriber) {
+	for {
+		select {
+		case <-sub.done:
+			return
+		case sample := <-sub.samples:
+			if err := sub.sink.WriteSample(sample); err != nil {
+				writeErrors.WithLabelValues(h.kind).Inc()
+			}
+		}
+	}
+}
+
+// Broadcast pushes sample to every current subscriber without blocking the
+// caller. A subscriber whose queue is already full is considered behind and
+// has this sample dropped for it rather than stalling the shared reader
+// loop that every other subscriber also depends on.
+func (h *Hub) Broadcast(sample media.Sample) {
+	h.mu.Lock()
+	subs := make([]*subscriber, len(h.subs))
+	copy(subs, h.subs)
+	h.mu.Unlock()
+
+	for _, sub := range subs {
+		select {
+		case sub.samples <- sample:
+		default:
+			droppedSamples.WithLabelValues(h.kind).Inc()
+		}
+	}
+}
+
+var (
+	subscriberCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "webrtc_mediasource_subscribers",
+			Help: "Number of active subscribers attached to a mediasource Hub",
+		},
+		[]string{"kind"},
+	)
+	droppedSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webrtc_mediasource_dropped_samples_total",
+			Help: "Samples dropped for a subscriber whose queue was full",
+		},
+		[]string{"kind"},
+	)
+	writeErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webrtc_mediasource_write_errors_total",
+			Help: "Errors returned by a subscriber's WriteSample",
+		},
+		[]string{"kind"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(subscriberCount, droppedSamples, writeErrors)
+}
@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package mediasource
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// SourceFormat identifies the codec of the decoded media this package is
+// about to send, independent of what the remote peer actually negotiated.
+type SourceFormat string
+
+// Supported source formats. IVF files carry AV1, VP9 or VP8; H264/H265
+// sources are expected to come from an Annex B elementary stream reader;
+// OGG files carry Opus.
+const (
+	SourceAV1  SourceFormat = "av1"
+	SourceVP9  SourceFormat = "vp9"
+	SourceVP8  SourceFormat = "vp8"
+	SourceH264 SourceFormat = "h264"
+	SourceH265 SourceFormat = "h265"
+	SourceOpus SourceFormat = "opus"
+)
+
+var sourceFormatMimeType = map[SourceFormat]string{
+	SourceAV1:  webrtc.MimeTypeAV1,
+	SourceVP9:  webrtc.MimeTypeVP9,
+	SourceVP8:  webrtc.MimeTypeVP8,
+	SourceH264: webrtc.MimeTypeH264,
+	SourceH265: webrtc.MimeTypeH265,
+	SourceOpus: webrtc.MimeTypeOpus,
+}
+
+// sourceFormatEncodingName is the RTP encoding name (the token before the
+// "/" in an "a=rtpmap" line) used by each SourceFormat, for matching
+// against remoteSDP directly.
+var sourceFormatEncodingName = map[SourceFormat]string{
+	SourceAV1:  "AV1",
+	SourceVP9:  "VP9",
+	SourceVP8:  "VP8",
+	SourceH264: "H264",
+	SourceH265: "H265",
+	SourceOpus: "opus",
+}
+
+// NewTrack builds a TrackLocalStaticSample whose RTP codec capability
+// (clock rate and fmtp line) matches what remoteSDP actually offered or
+// answered for sourceFormat, instead of assuming the peer supports
+// whatever codec the local file happens to be encoded as.
+//
+// It works by parsing remoteSDP directly and scanning its "a=rtpmap"/
+// "a=fmtp" attributes for sourceFormat's encoding name, the same
+// information a webrtc.MediaEngine would otherwise derive for us. The RTP
+// packetizer (AV1 OBU, H264 STAP-A/FU-A, VP8, VP9) is selected internally
+// by TrackLocalStaticSample from the resulting MimeType.
+func NewTrack(remoteSDP webrtc.SessionDescription, sourceFormat SourceFormat) (*webrtc.TrackLocalStaticSample, error) {
+	mimeType, ok := sourceFormatMimeType[sourceFormat]
+	if !ok {
+		return nil, fmt.Errorf("mediasource: unknown source format %q", sourceFormat)
+	}
+
+	var parsed sdp.SessionDescription
+	if err := parsed.Unmarshal([]byte(remoteSDP.SDP)); err != nil {
+		return nil, fmt.Errorf("mediasource: parse remote SDP: %w", err)
+	}
+
+	capability, err := negotiatedCapability(&parsed, mimeType, sourceFormatEncodingName[sourceFormat])
+	if err != nil {
+		return nil, err
+	}
+
+	return webrtc.NewTrackLocalStaticSample(capability, string(sourceFormat), "pion")
+}
+
+// negotiatedCapability scans desc's media sections for an "a=rtpmap" entry
+// whose encoding name matches encodingName, and returns the resulting
+// RTPCodecCapability with whatever clock rate, channel count and fmtp line
+// the remote peer advertised for it.
+func negotiatedCapability(desc *sdp.SessionDescription, mimeType, encodingName string) (webrtc.RTPCodecCapability, error) {
+	for _, media := range desc.MediaDescriptions {
+		for _, format := range media.MediaName.Formats {
+			payloadType, err := strconv.Atoi(format)
+			if err != nil {
+				continue
+			}
+
+			name, clockRate, channels, ok := rtpMap(media, payloadType)
+			if !ok || !strings.EqualFold(name, encodingName) {
+				continue
+			}
+
+			return webrtc.RTPCodecCapability{
+				MimeType:    mimeType,
+				ClockRate:   clockRate,
+				Channels:    channels,
+				SDPFmtpLine: fmtpLine(media, payloadType),
+			}, nil
+		}
+	}
+
+	return webrtc.RTPCodecCapability{}, fmt.Errorf("mediasource: remote SDP does not offer codec %s", encodingName)
+}
+
+// rtpMap finds the "a=rtpmap:<payloadType> <name>/<clockRate>[/<channels>]"
+// attribute for payloadType within media and parses it.
+func rtpMap(media *sdp.MediaDescription, payloadType int) (name string, clockRate uint32, channels uint16, ok bool) {
+	prefix := strconv.Itoa(payloadType) + " "
+
+	for _, attr := range media.Attributes {
+		if attr.Key != "rtpmap" || !strings.HasPrefix(attr.Value, prefix) {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(attr.Value, prefix), "/", 3)
+		if len(parts) < 2 {
+			return "", 0, 0, false
+		}
+
+		rate, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return "", 0, 0, false
+		}
+
+		var ch uint64
+		if len(parts) == 3 {
+			ch, _ = strconv.ParseUint(parts[2], 10, 16)
+		}
+
+		return parts[0], uint32(rate), uint16(ch), true
+	}
+
+	return "", 0, 0, false
+}
+
+// fmtpLine returns the "a=fmtp:<payloadType> ..." value for payloadType
+// within media, or "" if the remote peer didn't send one.
+func fmtpLine(media *sdp.MediaDescription, payloadType int) string {
+	prefix := strconv.Itoa(payloadType) + " "
+
+	for _, attr := range media.Attributes {
+		if attr.Key == "fmtp" && strings.HasPrefix(attr.Value, prefix) {
+			return strings.TrimPrefix(attr.Value, prefix)
+		}
+	}
+
+	return ""
+}
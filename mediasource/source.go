@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package mediasource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/pion/webrtc/v4/pkg/media/ivfreader"
+	"github.com/pion/webrtc/v4/pkg/media/oggreader"
+)
+
+// RunIVFSource decodes fileName as IVF in a loop, broadcasting one
+// media.Sample per frame on hub, and restarting from the beginning of the
+// file on EOF. It blocks until ctx is done, so callers should run it in its
+// own goroutine.
+func RunIVFSource(ctx context.Context, fileName string, hub *Hub) error {
+	for {
+		if err := runIVFOnce(ctx, fileName, hub); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+	}
+}
+
+func runIVFOnce(ctx context.Context, fileName string, hub *Hub) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("mediasource: open %s: %w", fileName, err)
+	}
+	defer file.Close()
+
+	ivf, header, err := ivfreader.NewWith(file)
+	if err != nil {
+		return fmt.Errorf("mediasource: read IVF header: %w", err)
+	}
+
+	ticker := time.NewTicker(
+		time.Millisecond * time.Duration((float32(header.TimebaseNumerator)/float32(header.TimebaseDenominator))*1000),
+	)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		frame, _, err := ivf.ParseNextFrame()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("mediasource: parse IVF frame: %w", err)
+		}
+
+		hub.Broadcast(media.Sample{Data: frame, Duration: time.Second})
+	}
+}
+
+// RunOGGSource decodes fileName as OGG/Opus in a loop, broadcasting one
+// media.Sample per page on hub, and restarting from the beginning of the
+// file on EOF. It blocks until ctx is done, so callers should run it in its
+// own goroutine.
+func RunOGGSource(ctx context.Context, fileName string, hub *Hub) error {
+	for {
+		if err := runOGGOnce(ctx, fileName, hub); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+	}
+}
+
+func runOGGOnce(ctx context.Context, fileName string, hub *Hub) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("mediasource: open %s: %w", fileName, err)
+	}
+	defer file.Close()
+
+	ogg, _, err := oggreader.NewWith(file)
+	if err != nil {
+		return fmt.Errorf("mediasource: read OGG header: %w", err)
+	}
+
+	const pageDuration = time.Millisecond * 20
+
+	ticker := time.NewTicker(pageDuration)
+	defer ticker.Stop()
+
+	var lastGranule uint64
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		pageData, pageHeader, err := ogg.ParseNextPage()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("mediasource: parse OGG page: %w", err)
+		}
+
+		sampleCount := float64(pageHeader.GranulePosition - lastGranule)
+		lastGranule = pageHeader.GranulePosition
+		sampleDuration := time.Duration((sampleCount/48000)*1000) * time.Millisecond
+
+		hub.Broadcast(media.Sample{Data: pageData, Duration: sampleDuration})
+	}
+}
@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package metrics turns the application-level timestamps carried on the
+// data channel into Prometheus series. It exists so main's OnMessage
+// handler has one call to make instead of poking a handful of globals with
+// constant label values directly.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DataChannelMessage is the payload exchanged over the data channel. Each
+// MessageSentTime_* field is filled in by the hop named by its suffix as
+// the message travels client2 -> sfu2 -> sfu1 -> client1.
+type DataChannelMessage struct {
+	FrameID                int64  `json:"frameID"`
+	MessageSentTimeClient2 int64  `json:"messageSentTime_client2,omitempty"`
+	MessageSentTimeSfu2    int64  `json:"messageSentTime_sfu2,omitempty"`
+	MessageSentTimeSfu1    int64  `json:"messageSentTime_sfu1,omitempty"`
+	MessageSentTimeClient1 int64  `json:"messageSentTime_client1,omitempty"`
+	JitterSFU2             int64  `json:"jitter_sfu2,omitempty"`
+	JitterSFU1             int64  `json:"jitter_sfu1,omitempty"`
+	LatencyEndToEnd        int64  `json:"latency_end_to_end,omitempty"`
+	MessageSendRate        int64  `json:"message_send_rate,omitempty"`
+	Payload                []byte `json:"payload"`
+}
+
+// latencyBuckets spans 1ms to ~4.1s, doubling each step, which is enough
+// resolution for anything from a LAN hop to a badly congested WAN path.
+var latencyBuckets = prometheus.ExponentialBuckets(1, 2, 13)
+
+var (
+	lastFrameID = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webrtc_frame_id",
+		Help: "FrameID of the most recently processed data channel message",
+	})
+	lastMessageSentTimeClient2 = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webrtc_message_sent_time_client2",
+		Help: "Unix ms timestamp the most recent message was sent from client 2",
+	})
+	lastMessageSentTimeSfu2 = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webrtc_message_sent_time_sfu2",
+		Help: "Unix ms timestamp the most recent message was sent from SFU 2",
+	})
+	lastMessageSentTimeSfu1 = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webrtc_message_sent_time_sfu1",
+		Help: "Unix ms timestamp the most recent message was sent from SFU 1",
+	})
+	lastMessageSentTimeClient1 = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webrtc_message_sent_time_client1",
+		Help: "Unix ms timestamp the most recent message was received at client 1",
+	})
+
+	framesReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "webrtc_frames_received_total",
+		Help: "Data channel messages successfully decoded and observed",
+	})
+	unmarshalErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "webrtc_frame_decode_errors_total",
+		Help: "Data channel messages that failed to unmarshal",
+	})
+
+	dataChannelBufferedAmount = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webrtc_datachannel_buffered_amount_bytes",
+		Help: "Current RTCDataChannel.bufferedAmount",
+	})
+
+	latencyEndToEnd = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "webrtc_latency_end_to_end_ms",
+		Help:    "client2 -> client1 end-to-end latency",
+		Buckets: latencyBuckets,
+	})
+	latencyClient2ToSfu2 = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "webrtc_latency_client2_to_sfu2_ms",
+		Help:    "client2 -> SFU2 latency",
+		Buckets: latencyBuckets,
+	})
+	latencySfu2ToSfu1 = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "webrtc_latency_sfu2_to_sfu1_ms",
+		Help:    "SFU2 -> SFU1 latency",
+		Buckets: latencyBuckets,
+	})
+	latencySfu1ToClient1 = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "webrtc_latency_sfu1_to_client1_ms",
+		Help:    "SFU1 -> client1 latency",
+		Buckets: latencyBuckets,
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		lastFrameID,
+		lastMessageSentTimeClient2,
+		lastMessageSentTimeSfu2,
+		lastMessageSentTimeSfu1,
+		lastMessageSentTimeClient1,
+		framesReceived,
+		unmarshalErrors,
+		dataChannelBufferedAmount,
+		latencyEndToEnd,
+		latencyClient2ToSfu2,
+		latencySfu2ToSfu1,
+		latencySfu1ToClient1,
+	)
+}
+
+// Observe records every metric derived from msg and returns msg with
+// LatencyEndToEnd filled in, ready to be logged to CSV. msg.MessageSentTimeClient1
+// must already be set by the caller before Observe is called.
+func Observe(msg DataChannelMessage) DataChannelMessage {
+	framesReceived.Inc()
+
+	lastFrameID.Set(float64(msg.FrameID))
+	lastMessageSentTimeClient2.Set(float64(msg.MessageSentTimeClient2))
+	lastMessageSentTimeSfu2.Set(float64(msg.MessageSentTimeSfu2))
+	lastMessageSentTimeSfu1.Set(float64(msg.MessageSentTimeSfu1))
+	lastMessageSentTimeClient1.Set(float64(msg.MessageSentTimeClient1))
+
+	endToEnd := float64(msg.MessageSentTimeClient1 - msg.MessageSentTimeClient2)
+	latencyEndToEnd.Observe(endToEnd)
+	latencyClient2ToSfu2.Observe(float64(msg.MessageSentTimeSfu2 - msg.MessageSentTimeClient2))
+	latencySfu2ToSfu1.Observe(float64(msg.MessageSentTimeSfu1 - msg.MessageSentTimeSfu2))
+	latencySfu1ToClient1.Observe(float64(msg.MessageSentTimeClient1 - msg.MessageSentTimeSfu1))
+
+	msg.LatencyEndToEnd = int64(endToEnd)
+
+	return msg
+}
+
+// ObserveUnmarshalError increments the decode-error counter for a data
+// channel message that failed json.Unmarshal.
+func ObserveUnmarshalError() {
+	unmarshalErrors.Inc()
+}
+
+// SetDataChannelBufferedAmount records the current RTCDataChannel.bufferedAmount.
+func SetDataChannelBufferedAmount(bytes float64) {
+	dataChannelBufferedAmount.Set(bytes)
+}
@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ntpEpochOffset is the number of seconds between the NTP epoch (1900-01-01)
+// and the Unix epoch (1970-01-01), needed to build NTP timestamps for
+// SenderReports and to interpret the LSR/DLSR fields of ReceiverReports.
+const ntpEpochOffset = 2208988800
+
+var (
+	rtpJitterMs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webrtc_rtp_jitter_ms",
+		Help: "Interarrival jitter reported in the most recent RTCP Receiver Report",
+	}, []string{"kind", "ssrc"})
+	rtpPacketsLost = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webrtc_rtp_packets_lost",
+		Help: "Cumulative number of packets lost, from the most recent RTCP Receiver Report",
+	}, []string{"kind", "ssrc"})
+	rtpFractionLost = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webrtc_rtp_fraction_lost",
+		Help: "Fraction of packets lost since the previous Receiver Report, as 0..1",
+	}, []string{"kind", "ssrc"})
+	rtpRTTMs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webrtc_rtp_rtt_ms",
+		Help: "Round-trip time derived from a Receiver Report's LSR/DLSR fields",
+	}, []string{"kind", "ssrc"})
+)
+
+func init() {
+	prometheus.MustRegister(rtpJitterMs, rtpPacketsLost, rtpFractionLost, rtpRTTMs)
+}
+
+// ObserveReceiverReport records the QoS fields of a single RTCP
+// ReceptionReport block for an outgoing RTP stream of the given kind
+// ("audio" or "video"). clockRateHz converts the report's jitter, which is
+// in RTP timestamp units, into milliseconds.
+func ObserveReceiverReport(kind string, clockRateHz uint32, report rtcp.ReceptionReport) {
+	ssrc := ssrcLabel(report.SSRC)
+
+	rtpFractionLost.WithLabelValues(kind, ssrc).Set(float64(report.FractionLost) / 256)
+	rtpPacketsLost.WithLabelValues(kind, ssrc).Set(float64(report.TotalLost))
+
+	if clockRateHz > 0 {
+		rtpJitterMs.WithLabelValues(kind, ssrc).Set(float64(report.Jitter) / float64(clockRateHz) * 1000)
+	}
+
+	if rtt, ok := roundTripTime(report.LastSenderReport, report.Delay); ok {
+		rtpRTTMs.WithLabelValues(kind, ssrc).Set(rtt)
+	}
+}
+
+// roundTripTime derives RTT in milliseconds from a Receiver Report's LSR
+// (last SR, middle 32 bits of the sender's NTP timestamp) and DLSR (delay
+// since last SR, both in 1/65536 second units), per RFC 3550 6.4.1. ok is
+// false when lsr is zero, meaning no SR has been received yet.
+func roundTripTime(lsr, dlsr uint32) (ms float64, ok bool) {
+	if lsr == 0 {
+		return 0, false
+	}
+
+	now := ntpShort(time.Now())
+	rttUnits := now - lsr - dlsr
+
+	return float64(rttUnits) / 65536 * 1000, true
+}
+
+// ntpShort returns the middle 32 bits of t's 64-bit NTP timestamp, the same
+// truncated form carried in an SR's "last SR" field.
+func ntpShort(t time.Time) uint32 {
+	secs := uint64(t.Unix()+ntpEpochOffset) << 32
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+
+	return uint32((secs | frac) >> 16)
+}
+
+// NTPTimestamp returns t as a full 64-bit NTP timestamp, suitable for the
+// NtpTime field of an outgoing rtcp.SenderReport.
+func NTPTimestamp(t time.Time) uint64 {
+	secs := uint64(t.Unix()+ntpEpochOffset) << 32
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+
+	return secs | frac
+}
+
+func ssrcLabel(ssrc uint32) string {
+	return strconv.FormatUint(uint64(ssrc), 10)
+}
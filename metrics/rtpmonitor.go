@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// senderReportInterval is how often we send an RTCP SenderReport for an
+// outgoing track, so the remote end has a recent LSR to derive RTT from.
+const senderReportInterval = 5 * time.Second
+
+// MonitorRTPStream feeds incoming RTCP Receiver Reports for sender into
+// ObserveReceiverReport and periodically writes a SenderReport for the same
+// stream. It blocks until ctx is done or the RTCP reader returns an error
+// (typically because the track was removed), so callers should run it in
+// its own goroutine.
+func MonitorRTPStream(ctx context.Context, kind string, clockRateHz uint32, pc *webrtc.PeerConnection, sender *webrtc.RTPSender) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		readReceiverReports(kind, clockRateHz, sender)
+		cancel()
+	}()
+	sendSenderReports(ctx, pc, sender)
+}
+
+func readReceiverReports(kind string, clockRateHz uint32, sender *webrtc.RTPSender) {
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := sender.Read(buf)
+		if err != nil {
+			return
+		}
+
+		packets, err := rtcp.Unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		for _, pkt := range packets {
+			rr, ok := pkt.(*rtcp.ReceiverReport)
+			if !ok {
+				continue
+			}
+
+			for _, report := range rr.Reports {
+				ObserveReceiverReport(kind, clockRateHz, report)
+			}
+		}
+	}
+}
+
+// sendSenderReports writes a SenderReport every senderReportInterval.
+// PacketCount/OctetCount are left at zero: TrackLocalStaticSample does not
+// expose the packetizer's running counters, so we can only offer the
+// timestamp half of the report; that's still enough for the receiver to
+// compute RTT from LSR/DLSR on its next Receiver Report.
+func sendSenderReports(ctx context.Context, pc *webrtc.PeerConnection, sender *webrtc.RTPSender) {
+	ticker := time.NewTicker(senderReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		params := sender.GetParameters()
+		if len(params.Encodings) == 0 {
+			continue
+		}
+
+		sr := &rtcp.SenderReport{
+			SSRC:    uint32(params.Encodings[0].SSRC),
+			NTPTime: NTPTimestamp(time.Now()),
+		}
+
+		if err := pc.WriteRTCP([]rtcp.Packet{sr}); err != nil {
+			return
+		}
+	}
+}
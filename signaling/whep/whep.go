@@ -0,0 +1,326 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package whep implements the server (answerer) side of the WHEP
+// (WebRTC-HTTP Egress Protocol) used by signaling/whip on the client:
+// a POST with an SDP offer body gets a new PeerConnection whose outgoing
+// tracks are subscribed to a mediasource.Hub, and the resulting answer is
+// returned with a Location header identifying the session for trickled
+// ICE candidates (PATCH) and teardown (DELETE).
+package whep
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/LikhithST/webrtc_client/mediasource"
+)
+
+// contentTypeTrickleICEFrag is the media type of a PATCH body carrying a
+// trickled ICE candidate, matching signaling/whip's use of the same type
+// for the client-to-server direction.
+const contentTypeTrickleICEFrag = "application/trickle-ice-sdpfrag"
+
+// Track pairs a Hub with the SourceFormat its samples are encoded as. Both
+// video and audio are optional; a nil Hub means the server doesn't offer
+// that kind of media.
+type Track struct {
+	Hub          *mediasource.Hub
+	Kind         string // "video" or "audio", used in error messages
+	SourceFormat mediasource.SourceFormat
+}
+
+// Server answers WHEP requests by handing each one a fresh PeerConnection
+// subscribed to Video and/or Audio. The zero value is not usable; construct
+// with NewServer.
+type Server struct {
+	Video, Audio Track
+	ICEServers   []webrtc.ICEServer
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	nextID   uint64
+}
+
+type session struct {
+	pc          *webrtc.PeerConnection
+	unsubscribe []func()
+
+	mu      sync.Mutex
+	pending [][]byte // our own trickled candidates not yet delivered to the viewer
+}
+
+// addLocalCandidate queues one of our own ICE candidates for delivery on the
+// viewer's next PATCH, since we have no channel to push it to them directly.
+func (sess *session) addLocalCandidate(candidate *webrtc.ICECandidate) {
+	if candidate == nil {
+		return
+	}
+
+	sess.mu.Lock()
+	sess.pending = append(sess.pending, candidateSDPFrag(candidate))
+	sess.mu.Unlock()
+}
+
+// drainLocalCandidates returns and clears every candidate queued since the
+// last call.
+func (sess *session) drainLocalCandidates() [][]byte {
+	sess.mu.Lock()
+	pending := sess.pending
+	sess.pending = nil
+	sess.mu.Unlock()
+
+	return pending
+}
+
+// NewServer returns a Server ready to be mounted as an http.Handler on the
+// "/whep/" subtree: session URLs it hands out and accepts are "/whep/<id>".
+// Either of video or audio may be its zero Track to omit that kind.
+func NewServer(video, audio Track, iceServers []webrtc.ICEServer) *Server {
+	return &Server{
+		Video:      video,
+		Audio:      audio,
+		ICEServers: iceServers,
+		sessions:   make(map[string]*session),
+	}
+}
+
+// ServeHTTP implements the WHEP HTTP surface: POST an SDP offer to create a
+// session, PATCH the session's own URL with a trickled ICE candidate, DELETE
+// the same URL to tear the session down.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.handleOffer(w, r)
+	case http.MethodPatch:
+		s.handlePatch(w, r)
+	case http.MethodDelete:
+		s.handleDelete(w, r)
+	default:
+		w.Header().Set("Allow", "POST, PATCH, DELETE")
+		http.Error(w, "whep: method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleOffer(w http.ResponseWriter, r *http.Request) {
+	offerBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("whep: read offer: %v", err), http.StatusBadRequest)
+		return
+	}
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: string(offerBody)}
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{ICEServers: s.ICEServers})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("whep: create peer connection: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Build each viewer's track from what their own offer advertises
+	// support for, rather than assuming they support whatever the source
+	// file happens to be encoded as.
+	unsubscribe, err := s.subscribeTracks(pc, offer)
+	if err != nil {
+		pc.Close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sess := &session{pc: pc, unsubscribe: unsubscribe}
+
+	// Queue our own candidates as they're found instead of blocking the
+	// answer on GatheringCompletePromise; they're delivered to the viewer
+	// as trickle-ice-sdpfrag fragments on its next PATCH, the same way
+	// whip.Client.TrickleCandidate delivers the offerer's candidates.
+	pc.OnICECandidate(sess.addLocalCandidate)
+
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		s.abort(pc, unsubscribe, w, fmt.Sprintf("whep: set remote description: %v", err))
+		return
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		s.abort(pc, unsubscribe, w, fmt.Sprintf("whep: create answer: %v", err))
+		return
+	}
+
+	if err := pc.SetLocalDescription(answer); err != nil {
+		s.abort(pc, unsubscribe, w, fmt.Sprintf("whep: set local description: %v", err))
+		return
+	}
+
+	id := s.addSession(sess)
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/whep/"+id)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(pc.LocalDescription().SDP))
+}
+
+// handlePatch accepts a trickled ICE candidate from the viewer, mirroring
+// signaling/whip's client-side TrickleCandidate on the answerer side, and
+// piggybacks any of our own candidates queued since the viewer's last PATCH
+// on the response - the only channel this server has to reach the viewer
+// outside of the initial answer.
+func (s *Server) handlePatch(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/whep/")
+
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	s.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "whep: unknown session", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("whep: read candidate: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	mid, candidate := parseCandidateSDPFrag(body)
+	if candidate != "" {
+		init := webrtc.ICECandidateInit{Candidate: candidate, SDPMid: &mid}
+		if err := sess.pc.AddICECandidate(init); err != nil {
+			http.Error(w, fmt.Sprintf("whep: add candidate: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	pending := sess.drainLocalCandidates()
+	if len(pending) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeTrickleICEFrag)
+	w.WriteHeader(http.StatusOK)
+	for _, frag := range pending {
+		w.Write(frag)
+	}
+}
+
+// subscribeTracks creates a fresh TrackLocalStaticSample per configured Hub,
+// negotiated against offer via mediasource.NewTrack so each viewer gets a
+// codec capability (clock rate, fmtp line) their own offer actually
+// advertised, adds it to pc, and subscribes it, returning the unsubscribe
+// funcs for both so the caller can tear them down together on any later
+// failure.
+func (s *Server) subscribeTracks(pc *webrtc.PeerConnection, offer webrtc.SessionDescription) (unsubscribe []func(), err error) {
+	for _, track := range []Track{s.Video, s.Audio} {
+		if track.Hub == nil {
+			continue
+		}
+
+		localTrack, trackErr := mediasource.NewTrack(offer, track.SourceFormat)
+		if trackErr != nil {
+			for _, u := range unsubscribe {
+				u()
+			}
+			return nil, fmt.Errorf("whep: negotiate %s track: %w", track.Kind, trackErr)
+		}
+
+		if _, trackErr := pc.AddTrack(localTrack); trackErr != nil {
+			for _, u := range unsubscribe {
+				u()
+			}
+			return nil, fmt.Errorf("whep: add %s track: %w", track.Kind, trackErr)
+		}
+
+		unsubscribe = append(unsubscribe, track.Hub.Subscribe(localTrack))
+	}
+
+	return unsubscribe, nil
+}
+
+func (s *Server) abort(pc *webrtc.PeerConnection, unsubscribe []func(), w http.ResponseWriter, msg string) {
+	for _, u := range unsubscribe {
+		u()
+	}
+	pc.Close()
+	http.Error(w, msg, http.StatusInternalServerError)
+}
+
+func (s *Server) addSession(sess *session) string {
+	id := itoa(atomic.AddUint64(&s.nextID, 1))
+
+	s.mu.Lock()
+	s.sessions[id] = sess
+	s.mu.Unlock()
+
+	return id
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/whep/")
+
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	if ok {
+		delete(s.sessions, id)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "whep: unknown session", http.StatusNotFound)
+		return
+	}
+
+	for _, u := range sess.unsubscribe {
+		u()
+	}
+	sess.pc.Close()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// candidateSDPFrag renders one of our own ICE candidates as a minimal SDP
+// media fragment, the same format signaling/whip's candidateSDPFrag uses in
+// the other direction.
+func candidateSDPFrag(candidate *webrtc.ICECandidate) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "a=mid:%s\r\n", candidate.SDPMid)
+	fmt.Fprintf(&b, "a=%s\r\n", candidate.ToJSON().Candidate)
+
+	return []byte(b.String())
+}
+
+// parseCandidateSDPFrag extracts the mid and candidate line out of a
+// trickle-ice-sdpfrag body shaped like candidateSDPFrag's output.
+func parseCandidateSDPFrag(body []byte) (mid, candidate string) {
+	for _, line := range strings.Split(string(body), "\r\n") {
+		switch {
+		case strings.HasPrefix(line, "a=mid:"):
+			mid = strings.TrimPrefix(line, "a=mid:")
+		case strings.HasPrefix(line, "a="):
+			candidate = strings.TrimPrefix(line, "a=")
+		}
+	}
+
+	return mid, candidate
+}
+
+func itoa(id uint64) string {
+	if id == 0 {
+		return "0"
+	}
+
+	var buf [20]byte
+	i := len(buf)
+	for id > 0 {
+		i--
+		buf[i] = byte('0' + id%10)
+		id /= 10
+	}
+
+	return string(buf[i:])
+}
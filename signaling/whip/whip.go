@@ -0,0 +1,278 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package whip implements a client for the WebRTC-HTTP Ingestion Protocol
+// (WHIP) and its read-side counterpart WHEP (WebRTC-HTTP Egress Protocol).
+//
+// Both protocols exchange a single SDP offer/answer over plain HTTP and use
+// the Location header returned from the initial POST as a per-session
+// resource URL for later trickle-ICE PATCHes and the final teardown DELETE.
+package whip
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	contentTypeSDP            = "application/sdp"
+	contentTypeTrickleICEFrag = "application/trickle-ice-sdpfrag"
+
+	// maxAttempts bounds the retries applied to a transient failure (a
+	// network error or 5xx response) talking to the WHIP/WHEP endpoint.
+	maxAttempts = 4
+	baseBackoff = 200 * time.Millisecond
+)
+
+// Client talks WHIP/WHEP to a single endpoint on behalf of one
+// PeerConnection. The zero value is not usable; construct with NewClient.
+type Client struct {
+	// Endpoint is the WHIP (or WHEP) ingest/egress URL, e.g.
+	// "https://sfu.example.com/whip/endpoint".
+	Endpoint string
+
+	// Token is sent as "Authorization: Bearer <Token>" when non-empty.
+	Token string
+
+	// HTTPClient is used for every request. Defaults to http.DefaultClient
+	// when nil.
+	HTTPClient *http.Client
+
+	mu          sync.Mutex
+	resourceURL string
+}
+
+// NewClient returns a Client ready to Publish or Subscribe against endpoint.
+// httpClient may be nil, in which case http.DefaultClient is used.
+func NewClient(endpoint, token string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{
+		Endpoint:   endpoint,
+		Token:      token,
+		HTTPClient: httpClient,
+	}
+}
+
+// Publish performs the WHIP offer/answer exchange: it creates an offer on
+// pc, POSTs it to the endpoint as application/sdp, and applies the SDP
+// answer from the response body as the remote description. The resource
+// URL returned in the Location header is returned so callers can trickle
+// candidates and DELETE on shutdown.
+//
+// pc.OnICECandidate should be wired to TrickleCandidate so candidates
+// gathered after Publish returns are still delivered.
+func (c *Client) Publish(ctx context.Context, pc *webrtc.PeerConnection) (string, error) {
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return "", fmt.Errorf("whip: create offer: %w", err)
+	}
+
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return "", fmt.Errorf("whip: set local description: %w", err)
+	}
+
+	return c.exchange(ctx, pc, offer)
+}
+
+// Subscribe performs the WHEP equivalent of Publish: it is identical on the
+// wire, but is provided under its own name so callers reading the SFU-facing
+// code can tell ingest and egress sessions apart at a glance.
+func (c *Client) Subscribe(ctx context.Context, pc *webrtc.PeerConnection) (string, error) {
+	return c.Publish(ctx, pc)
+}
+
+func (c *Client) exchange(ctx context.Context, pc *webrtc.PeerConnection, offer webrtc.SessionDescription) (string, error) {
+	resp, err := c.doWithRetry(ctx, http.MethodPost, c.Endpoint, contentTypeSDP, []byte(offer.SDP))
+	if err != nil {
+		return "", fmt.Errorf("whip: post offer: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("whip: post offer: unexpected status %s", resp.Status)
+	}
+
+	resourceURL, err := c.resolveResourceURL(resp.Header.Get("Location"))
+	if err != nil {
+		return "", fmt.Errorf("whip: resolve resource URL: %w", err)
+	}
+
+	answerSDP, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("whip: read answer: %w", err)
+	}
+
+	answer := webrtc.SessionDescription{
+		Type: webrtc.SDPTypeAnswer,
+		SDP:  string(answerSDP),
+	}
+	if err := pc.SetRemoteDescription(answer); err != nil {
+		return "", fmt.Errorf("whip: set remote description: %w", err)
+	}
+
+	c.mu.Lock()
+	c.resourceURL = resourceURL
+	c.mu.Unlock()
+
+	return resourceURL, nil
+}
+
+// resolveResourceURL turns a possibly-relative Location header into an
+// absolute URL relative to the configured Endpoint, per the WHIP spec.
+func (c *Client) resolveResourceURL(location string) (string, error) {
+	if location == "" {
+		return "", fmt.Errorf("missing Location header")
+	}
+
+	base, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	ref, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+
+	return base.ResolveReference(ref).String(), nil
+}
+
+// TrickleCandidate sends a single ICE candidate to the session's resource
+// URL as a PATCH carrying an application/trickle-ice-sdpfrag body. It is
+// intended to be called from pc.OnICECandidate once Publish/Subscribe has
+// produced a resource URL; calls received before that happens are dropped.
+//
+// A nil candidate signals end-of-candidates and is ignored, matching the
+// semantics of webrtc.PeerConnection.OnICECandidate.
+func (c *Client) TrickleCandidate(ctx context.Context, mid string, candidate *webrtc.ICECandidate) error {
+	if candidate == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	resourceURL := c.resourceURL
+	c.mu.Unlock()
+
+	if resourceURL == "" {
+		return fmt.Errorf("whip: no resource URL yet, dropping candidate")
+	}
+
+	frag := candidateSDPFrag(mid, candidate.ToJSON())
+
+	resp, err := c.doWithRetry(ctx, http.MethodPatch, resourceURL, contentTypeTrickleICEFrag, frag)
+	if err != nil {
+		return fmt.Errorf("whip: patch candidate: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("whip: patch candidate: unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// candidateSDPFrag renders a single ICE candidate as a minimal SDP media
+// fragment, as required by the trickle-ice-sdpfrag media type (RFC 8840
+// applied to WHIP's use of one m= section per mid).
+func candidateSDPFrag(mid string, candidate webrtc.ICECandidateInit) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "a=mid:%s\r\n", mid)
+	fmt.Fprintf(&b, "a=%s\r\n", candidate.Candidate)
+
+	return []byte(b.String())
+}
+
+// doWithRetry issues method against targetURL, retrying with exponential
+// backoff on a network error or 5xx response - a transient SFU hiccup
+// during publish or trickle shouldn't permanently break the session. body
+// may be nil; it is re-read from scratch on every attempt since an
+// http.Request's body can only be consumed once. Non-retryable responses
+// (including 4xx) are returned as-is for the caller to interpret.
+func (c *Client) doWithRetry(ctx context.Context, method, targetURL, contentType string, body []byte) (*http.Response, error) {
+	backoff := baseBackoff
+
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, targetURL, reader)
+		if err != nil {
+			return nil, fmt.Errorf("build %s request: %w", method, err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		c.setAuth(req)
+
+		resp, err := c.HTTPClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode >= http.StatusInternalServerError {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("server error: %s", resp.Status)
+			continue
+		}
+
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("%s %s failed after %d attempts: %w", method, targetURL, maxAttempts, lastErr)
+}
+
+func (c *Client) setAuth(req *http.Request) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+}
+
+// Close sends the DELETE that tears down the WHIP/WHEP session on the
+// remote server. It is a no-op if Publish/Subscribe never completed.
+func (c *Client) Close(ctx context.Context) error {
+	c.mu.Lock()
+	resourceURL := c.resourceURL
+	c.mu.Unlock()
+
+	if resourceURL == "" {
+		return nil
+	}
+
+	resp, err := c.doWithRetry(ctx, http.MethodDelete, resourceURL, "", nil)
+	if err != nil {
+		return fmt.Errorf("whip: delete session: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("whip: delete session: unexpected status %s", resp.Status)
+	}
+
+	return nil
+}